@@ -0,0 +1,126 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// runServer starts the HTTP API and blocks until an interrupt signal is
+// received, at which point it shuts the server down gracefully.
+func runServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/tides", handleTides)
+	mux.HandleFunc("/api/tides/next", handleNextTide)
+	mux.HandleFunc("/api/data/tide-stats/", handleTideStats)
+	mux.HandleFunc("/tides.ics", handleICS)
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		fmt.Println("Serving tide API on", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Error starting HTTP server:", err)
+		}
+	}()
+
+	waitForInterrupt()
+
+	fmt.Println("Shutting down HTTP server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Error shutting down HTTP server:", err)
+	}
+}
+
+// waitForInterrupt blocks until the process receives an interrupt signal.
+func waitForInterrupt() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+}
+
+// handleTides handles GET /api/tides?from=...&to=...&highlow=H&station=...
+// from/to are parsed as RFC3339 timestamps; highlow filters on "H" or "L".
+// All parameters are optional; an absent station returns tides for every
+// configured station.
+func handleTides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, err := parseFromTo(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	highlow := r.URL.Query().Get("highlow")
+	station := r.URL.Query().Get("station")
+
+	tides, err := store.Query(from, to, highlow, station)
+	if err != nil {
+		log.Println("Error querying tides:", err)
+		http.Error(w, "error querying tides", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, fromStorageTides(tides))
+}
+
+// handleNextTide handles GET /api/tides/next?station=..., returning the next
+// upcoming tide event relative to now, optionally restricted to one station.
+func handleNextTide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	station := r.URL.Query().Get("station")
+	tide, err := store.NextTide(time.Now(), station)
+	if err != nil {
+		log.Println("Error querying next tide:", err)
+		http.Error(w, "error querying next tide", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, fromStorageTide(tide))
+}
+
+// parseFromTo parses the optional from/to RFC3339 query parameters.
+func parseFromTo(r *http.Request) (from, to time.Time, err error) {
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
+// writeJSON writes v to w as a JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error encoding JSON response:", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+// Package ical renders tide predictions as an iCalendar (RFC 5545) feed so
+// they can be subscribed to from any CalDAV client or calendar app.
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-ical"
+)
+
+// lowTideAlarmLead is how far before a low tide a VALARM is scheduled,
+// for fishing/surfing use.
+const lowTideAlarmLead = 30 * time.Minute
+
+// TideEvent is the subset of a tide prediction needed to render a VEVENT.
+// DateTime.Location() determines which VTIMEZONE the VEVENT is rendered
+// against, so it must be a real IANA location, not a fixed-offset zone.
+type TideEvent struct {
+	StationID    string
+	DateTime     time.Time
+	HighLow      string
+	PredictionFt float64
+}
+
+// BuildCalendar renders tides as a VCALENDAR containing one VEVENT per tide.
+// A VTIMEZONE is emitted for each distinct location used by the tides'
+// DateTime values, so a feed spanning multiple stations' time zones renders
+// correctly, and a VALARM is added before each low tide.
+func BuildCalendar(tides []TideEvent) *ical.Calendar {
+	cal := ical.NewCalendar()
+	cal.Props.SetText(ical.PropVersion, "2.0")
+	cal.Props.SetText(ical.PropProductID, "-//TideCrawler//tides.ics//EN")
+
+	for _, loc := range distinctLocations(tides) {
+		cal.Children = append(cal.Children, newTimezone(loc))
+	}
+	for _, t := range tides {
+		cal.Children = append(cal.Children, newTideEvent(t).Component)
+	}
+	return cal
+}
+
+// distinctLocations returns the distinct time.Locations used by tides'
+// DateTime values, sorted by name so VTIMEZONE ordering is deterministic.
+func distinctLocations(tides []TideEvent) []*time.Location {
+	seen := make(map[string]*time.Location)
+	for _, t := range tides {
+		loc := t.DateTime.Location()
+		seen[loc.String()] = loc
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	locs := make([]*time.Location, len(names))
+	for i, name := range names {
+		locs[i] = seen[name]
+	}
+	return locs
+}
+
+// newTideEvent renders a single tide prediction as a VEVENT. DTSTART is set
+// from t.DateTime directly; its Location must match the TZID of a
+// VTIMEZONE emitted by BuildCalendar.
+func newTideEvent(t TideEvent) *ical.Event {
+	event := ical.NewEvent()
+	event.Props.SetText(ical.PropUID, uid(t))
+	event.Props.SetDateTime(ical.PropDateTimeStamp, time.Now())
+	event.Props.SetDateTime(ical.PropDateTimeStart, t.DateTime)
+	event.Props.SetText(ical.PropSummary, summary(t))
+
+	if t.HighLow == "L" {
+		event.Children = append(event.Children, newLowTideAlarm(t))
+	}
+	return event
+}
+
+// newLowTideAlarm builds a VALARM that fires lowTideAlarmLead before a low
+// tide.
+func newLowTideAlarm(t TideEvent) *ical.Component {
+	alarm := ical.NewComponent(ical.CompAlarm)
+	alarm.Props.SetText(ical.PropAction, "DISPLAY")
+	alarm.Props.SetText(ical.PropDescription, summary(t))
+
+	trigger := ical.NewProp(ical.PropTrigger)
+	trigger.SetDuration(-lowTideAlarmLead)
+	alarm.Props.Set(trigger)
+
+	return alarm
+}
+
+// newTimezone builds a minimal VTIMEZONE for loc with a single STANDARD
+// rule whose offset is loc's offset at the moment the calendar is built
+// (the daylight offset if DST is then in effect). Daylight-saving
+// transitions are not described, so one fixed offset is used to interpret
+// every DTSTART in that zone.
+func newTimezone(loc *time.Location) *ical.Component {
+	_, offset := time.Now().In(loc).Zone()
+
+	tz := ical.NewComponent(ical.CompTimezone)
+	tz.Props.SetText(ical.PropTimezoneID, loc.String())
+
+	standard := ical.NewComponent(ical.CompTimezoneStandard)
+	standard.Props.SetText(ical.PropTimezoneOffsetFrom, offsetString(offset))
+	standard.Props.SetText(ical.PropTimezoneOffsetTo, offsetString(offset))
+	standard.Props.SetDateTime(ical.PropDateTimeStart, time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	tz.Children = append(tz.Children, standard)
+
+	return tz
+}
+
+// summary renders the VEVENT SUMMARY text, e.g. "High tide 5.4 ft".
+func summary(t TideEvent) string {
+	kind := "Low tide"
+	if t.HighLow == "H" {
+		kind = "High tide"
+	}
+	return fmt.Sprintf("%s %.1f ft", kind, t.PredictionFt)
+}
+
+// uid derives a stable VEVENT UID from the station and tide time so
+// repeated exports of the same prediction produce the same UID.
+func uid(t TideEvent) string {
+	return fmt.Sprintf("%s-%s@tidecrawler", t.StationID, t.DateTime.UTC().Format("20060102T150405Z"))
+}
+
+// offsetString formats a UTC offset in seconds as e.g. "-0800".
+func offsetString(offsetSeconds int) string {
+	sign := "+"
+	if offsetSeconds < 0 {
+		sign = "-"
+		offsetSeconds = -offsetSeconds
+	}
+	hours := offsetSeconds / 3600
+	minutes := (offsetSeconds % 3600) / 60
+	return fmt.Sprintf("%s%02d%02d", sign, hours, minutes)
+}
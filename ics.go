@@ -0,0 +1,64 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+
+	goical "github.com/emersion/go-ical"
+
+	"github.com/johnbeil/TideCrawler/ical"
+)
+
+// toTideEvents converts Tide records into ical.TideEvent values.
+func toTideEvents(tides []Tide) []ical.TideEvent {
+	events := make([]ical.TideEvent, 0, len(tides))
+	for _, t := range tides {
+		events = append(events, ical.TideEvent{
+			StationID:    t.StationID,
+			DateTime:     t.DateTime,
+			HighLow:      t.HighLow,
+			PredictionFt: t.PredictionFt,
+		})
+	}
+	return events
+}
+
+// writeICSFile renders tides as an iCalendar feed and writes it to path.
+func writeICSFile(path string, tides []Tide) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cal := ical.BuildCalendar(toTideEvents(tides))
+	return goical.NewEncoder(f).Encode(cal)
+}
+
+// handleICS handles GET /tides.ics?station=..., serving stored tides as an
+// iCalendar feed suitable for subscribing from a CalDAV client. An absent
+// station includes every configured station.
+func handleICS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	station := r.URL.Query().Get("station")
+	tides, err := store.Query(time.Time{}, time.Time{}, "", station)
+	if err != nil {
+		http.Error(w, "error querying tides", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar")
+	cal := ical.BuildCalendar(toTideEvents(fromStorageTides(tides)))
+	if err := goical.NewEncoder(w).Encode(cal); err != nil {
+		http.Error(w, "error encoding calendar", http.StatusInternalServerError)
+	}
+}
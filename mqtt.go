@@ -0,0 +1,196 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/johnbeil/TideCrawler/storage"
+)
+
+// mqttControlTopic carries out-of-band commands; publishing "refresh" to it
+// republishes every station's next/today tides immediately.
+const mqttControlTopic = "tides/control"
+
+// mqttPublishInterval is how often tides are republished on a timer,
+// independent of the control topic and the ingest cycle.
+const mqttPublishInterval = 15 * time.Minute
+
+// MQTTConfig configures the optional MQTT publisher.
+type MQTTConfig struct {
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// mqttNextTide is the JSON payload published for a single tide event.
+type mqttNextTide struct {
+	DateTime time.Time `json:"datetime"`
+	HighLow  string    `json:"highlow"`
+	Ft       float64   `json:"ft"`
+	Cm       float64   `json:"cm"`
+}
+
+// mqttPublisher keeps an MQTT connection open and republishes each
+// station's upcoming tide whenever asked, resubscribing its control topic
+// whenever the connection comes up (including after a reconnect).
+type mqttPublisher struct {
+	client   mqtt.Client
+	stations []Station
+}
+
+// startMQTT connects to the broker described by config and begins
+// publishing the upcoming tide for every station, both immediately and on
+// a timer, until the process exits.
+func startMQTT(config MQTTConfig, stations []Station) (*mqttPublisher, error) {
+	p := &mqttPublisher{stations: stations}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(true).
+		SetKeepAlive(30 * time.Second).
+		SetOnConnectHandler(p.onConnect).
+		SetConnectionLostHandler(p.onConnectionLost)
+
+	if config.CAFile != "" || config.CertFile != "" {
+		tlsConfig, err := buildMQTTTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	p.client = mqtt.NewClient(opts)
+	if token := p.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	go p.loop()
+	return p, nil
+}
+
+// onConnect resubscribes the control topic and republishes every station's
+// tides. It runs both on the first connect and every reconnect.
+func (p *mqttPublisher) onConnect(client mqtt.Client) {
+	fmt.Println("MQTT connected, resubscribing control topics...")
+	if token := client.Subscribe(mqttControlTopic, 1, p.handleControl); token.Wait() && token.Error() != nil {
+		log.Println("Error resubscribing to MQTT control topic:", token.Error())
+	}
+	p.publishAll()
+}
+
+func (p *mqttPublisher) onConnectionLost(client mqtt.Client, err error) {
+	log.Println("MQTT connection lost, will reconnect automatically:", err)
+}
+
+// handleControl triggers an immediate republish on receiving "refresh".
+func (p *mqttPublisher) handleControl(client mqtt.Client, msg mqtt.Message) {
+	if string(msg.Payload()) == "refresh" {
+		p.publishAll()
+	}
+}
+
+// loop republishes every station's tides on a timer until the process
+// exits.
+func (p *mqttPublisher) loop() {
+	ticker := time.NewTicker(mqttPublishInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.publishAll()
+	}
+}
+
+// publishAll publishes the next tide and today's tides for every station.
+func (p *mqttPublisher) publishAll() {
+	for _, s := range p.stations {
+		if err := p.publishStation(s); err != nil {
+			log.Println("Error publishing MQTT tides for station", s.ID, ":", err)
+		}
+	}
+}
+
+func (p *mqttPublisher) publishStation(s Station) error {
+	next, err := store.NextTide(time.Now(), s.ID)
+	if err != nil {
+		return err
+	}
+	if err := p.publish(fmt.Sprintf("tides/%s/next", s.ID), false, toMQTTNextTide(next)); err != nil {
+		return err
+	}
+
+	today, err := store.Query(startOfDay(time.Now()), endOfDay(time.Now()), "", s.ID)
+	if err != nil {
+		return err
+	}
+	return p.publish(fmt.Sprintf("tides/%s/today", s.ID), true, toMQTTNextTides(today))
+}
+
+// publish marshals v as JSON and publishes it to topic, retaining the
+// message when retained is true (used for the day's tide list so new
+// subscribers immediately see the current day).
+func (p *mqttPublisher) publish(topic string, retained bool, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	token := p.client.Publish(topic, 1, retained, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func toMQTTNextTide(t storage.Tide) mqttNextTide {
+	return mqttNextTide{DateTime: t.DateTime, HighLow: t.HighLow, Ft: t.PredictionFt, Cm: t.PredictionCm}
+}
+
+func toMQTTNextTides(tides []storage.Tide) []mqttNextTide {
+	out := make([]mqttNextTide, len(tides))
+	for i, t := range tides {
+		out[i] = toMQTTNextTide(t)
+	}
+	return out
+}
+
+// buildMQTTTLSConfig builds a tls.Config from the configured CA/cert/key
+// files for connecting to a broker over TLS.
+func buildMQTTTLSConfig(config MQTTConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if config.CAFile != "" {
+		caCert, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading MQTT CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading MQTT client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
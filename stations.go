@@ -0,0 +1,48 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// defaultStationsConfig is used when STATIONS_CONFIG is not set.
+const defaultStationsConfig = "stations.yaml"
+
+// Station describes a single NOAA tide station to fetch predictions for.
+type Station struct {
+	ID          string `yaml:"id"`
+	Name        string `yaml:"name"`
+	Timezone    string `yaml:"timezone"`
+	Location    string `yaml:"location"`
+	URLTemplate string `yaml:"urlTemplate"`
+}
+
+// stationsFile is the top-level shape of the YAML stations config.
+type stationsFile struct {
+	Stations []Station `yaml:"stations"`
+}
+
+// url returns the fully-formed NOAA annual tide XML URL for this station.
+func (s Station) url() string {
+	return fmt.Sprintf(s.URLTemplate, s.ID)
+}
+
+// loadStations reads and parses the station list from a YAML config file.
+func loadStations(path string) ([]Station, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f stationsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	return f.Stations, nil
+}
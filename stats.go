@@ -0,0 +1,175 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DayStats summarizes tide heights observed on a single day.
+type DayStats struct {
+	Date   string  `json:"date"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	Q25    float64 `json:"q25"`
+	Q75    float64 `json:"q75"`
+}
+
+// handleTideStats handles GET /api/data/tide-stats/{stationId}?from=...&to=...&format=csv|json
+func handleTideStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	station := strings.TrimPrefix(r.URL.Path, "/api/data/tide-stats/")
+
+	from, to, err := parseStatsRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tides, err := store.Query(from, to, "", station)
+	if err != nil {
+		log.Println("Error querying tides for stats:", err)
+		http.Error(w, "error querying tides", http.StatusInternalServerError)
+		return
+	}
+
+	stats := bucketDailyStats(fromStorageTides(tides))
+
+	if r.URL.Query().Get("format") == "csv" {
+		writeStatsCSV(w, stats)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// parseStatsRange parses the optional from/to query parameters, defaulting
+// to the last year ending today when absent.
+func parseStatsRange(r *http.Request) (from, to time.Time, err error) {
+	to = endOfDay(time.Now())
+	from = to.AddDate(-1, 0, 0)
+
+	if v := r.URL.Query().Get("from"); v != "" {
+		from, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return from, to, err
+		}
+	}
+	return from, to, nil
+}
+
+// endOfDay returns the last instant of the day containing t.
+func endOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 23, 59, 59, 0, t.Location())
+}
+
+// startOfDay returns the first instant of the day containing t.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// bucketDailyStats groups tides by calendar day and computes height
+// statistics (in feet) for each day.
+func bucketDailyStats(tides []Tide) []DayStats {
+	buckets := make(map[string][]float64)
+	var order []string
+	for _, t := range tides {
+		key := t.DateTime.Format("2006-01-02")
+		if _, ok := buckets[key]; !ok {
+			order = append(order, key)
+		}
+		buckets[key] = append(buckets[key], t.PredictionFt)
+	}
+	sort.Strings(order)
+
+	stats := make([]DayStats, 0, len(order))
+	for _, key := range order {
+		s := computeStats(buckets[key])
+		s.Date = key
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// computeStats returns min, max, mean, median, and 25th/75th percentiles
+// (by linear interpolation) of values. values is sorted in place.
+func computeStats(values []float64) DayStats {
+	sort.Float64s(values)
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+
+	return DayStats{
+		Min:    values[0],
+		Max:    values[len(values)-1],
+		Mean:   sum / float64(len(values)),
+		Median: percentile(values, 0.5),
+		Q25:    percentile(values, 0.25),
+		Q75:    percentile(values, 0.75),
+	}
+}
+
+// percentile returns the p-th percentile (0 <= p <= 1) of a sorted slice
+// using linear interpolation between the two nearest ranks.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+
+	rank := p * float64(len(sorted)-1)
+	lo := int(rank)
+	hi := lo + 1
+	if hi >= len(sorted) {
+		return sorted[lo]
+	}
+	frac := rank - float64(lo)
+	return sorted[lo] + frac*(sorted[hi]-sorted[lo])
+}
+
+// writeStatsCSV writes stats as CSV rows of date,min,max,mean,median,Q25,Q75.
+func writeStatsCSV(w http.ResponseWriter, stats []DayStats) {
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	cw.Write([]string{"date", "min", "max", "mean", "median", "Q25", "Q75"})
+	for _, s := range stats {
+		cw.Write([]string{
+			s.Date,
+			formatFloat(s.Min),
+			formatFloat(s.Max),
+			formatFloat(s.Mean),
+			formatFloat(s.Median),
+			formatFloat(s.Q25),
+			formatFloat(s.Q75),
+		})
+	}
+}
+
+// formatFloat formats a tide height with two decimal places.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestComputeStatsOddCount(t *testing.T) {
+	values := []float64{1.0, 3.0, 2.0, 5.0, 4.0}
+	stats := computeStats(values)
+
+	if stats.Min != 1.0 {
+		t.Errorf("expected min 1.0, got %v", stats.Min)
+	}
+	if stats.Max != 5.0 {
+		t.Errorf("expected max 5.0, got %v", stats.Max)
+	}
+	if stats.Mean != 3.0 {
+		t.Errorf("expected mean 3.0, got %v", stats.Mean)
+	}
+	if stats.Median != 3.0 {
+		t.Errorf("expected median 3.0, got %v", stats.Median)
+	}
+}
+
+func TestComputeStatsEvenCount(t *testing.T) {
+	values := []float64{1.0, 2.0, 3.0, 4.0}
+	stats := computeStats(values)
+
+	if stats.Median != 2.5 {
+		t.Errorf("expected median 2.5, got %v", stats.Median)
+	}
+	if stats.Q25 != 1.75 {
+		t.Errorf("expected Q25 1.75, got %v", stats.Q25)
+	}
+	if stats.Q75 != 3.25 {
+		t.Errorf("expected Q75 3.25, got %v", stats.Q75)
+	}
+}
+
+func TestComputeStatsSingleValue(t *testing.T) {
+	stats := computeStats([]float64{2.5})
+
+	if stats.Min != 2.5 || stats.Max != 2.5 || stats.Mean != 2.5 || stats.Median != 2.5 {
+		t.Errorf("expected all stats to equal 2.5, got %+v", stats)
+	}
+	if stats.Q25 != 2.5 || stats.Q75 != 2.5 {
+		t.Errorf("expected quartiles to equal 2.5, got %+v", stats)
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// mysqlUpsertBatchSize is the number of rows sent per multi-row INSERT.
+const mysqlUpsertBatchSize = 1000
+
+// mysqlMigrations lists every schema change in order. Never edit a
+// migration once it has shipped; add a new one instead.
+var mysqlMigrations = []struct {
+	Version int
+	SQL     string
+}{
+	{
+		Version: 1,
+		SQL: "CREATE TABLE IF NOT EXISTS tidedata(" +
+			"uid INT AUTO_INCREMENT PRIMARY KEY, " +
+			"station_id VARCHAR(16), " +
+			"`datetime` DATETIME, " +
+			"`date` VARCHAR(16), " +
+			"`day` VARCHAR(16), " +
+			"`time` VARCHAR(16), " +
+			"predictionft FLOAT, " +
+			"predictioncm INT, " +
+			"highlow VARCHAR(16), " +
+			"UNIQUE KEY station_datetime (station_id, `datetime`)" +
+			")",
+	},
+}
+
+// mysqlStore is a Store backed by go-sql-driver/mysql.
+type mysqlStore struct {
+	db *sql.DB
+}
+
+func newMySQLStore(dsn string) (Store, error) {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &mysqlStore{db: db}, nil
+}
+
+func (s *mysqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *mysqlStore) Migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations(version INT PRIMARY KEY, applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := s.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %v", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range mysqlMigrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %v", m.Version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations(version) VALUES(?)", m.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTides upserts tides in chunks of mysqlUpsertBatchSize rows. MySQL
+// lacks RETURNING, so inserted/updated/unchanged are classified by comparing
+// against existing rows before writing, rather than inspecting the write
+// itself.
+func (s *mysqlStore) SaveTides(tides []Tide, dryRun bool) (UpsertResult, error) {
+	var total UpsertResult
+	for start := 0; start < len(tides); start += mysqlUpsertBatchSize {
+		end := start + mysqlUpsertBatchSize
+		if end > len(tides) {
+			end = len(tides)
+		}
+
+		result, err := s.upsertBatch(tides[start:end], dryRun)
+		if err != nil {
+			return total, err
+		}
+		total.Inserted += result.Inserted
+		total.Updated += result.Updated
+		total.Unchanged += result.Unchanged
+	}
+	return total, nil
+}
+
+func (s *mysqlStore) upsertBatch(tides []Tide, dryRun bool) (UpsertResult, error) {
+	existing, err := s.existingByKey(tides)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	for _, t := range tides {
+		switch prev, ok := existing[tideKey(t.StationID, t.DateTime)]; {
+		case !ok:
+			result.Inserted++
+		case prev.Equal(t):
+			result.Unchanged++
+		default:
+			result.Updated++
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	placeholders := make([]string, len(tides))
+	args := make([]interface{}, 0, len(tides)*8)
+	for i, t := range tides {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, t.StationID, t.DateTime, t.Date, t.Day, t.Time, t.PredictionFt, t.PredictionCm, t.HighLow)
+	}
+
+	query := fmt.Sprintf("INSERT INTO tidedata(station_id, `datetime`, `date`, `day`, `time`, predictionft, predictioncm, highlow) VALUES %s "+
+		"ON DUPLICATE KEY UPDATE `date` = VALUES(`date`), `day` = VALUES(`day`), `time` = VALUES(`time`), "+
+		"predictionft = VALUES(predictionft), predictioncm = VALUES(predictioncm), highlow = VALUES(highlow)", strings.Join(placeholders, ", "))
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		tx.Rollback()
+		return UpsertResult{}, err
+	}
+	return result, tx.Commit()
+}
+
+// existingByKey fetches the current rows for any of tides' (station_id,
+// datetime) keys, for use diffing against incoming data.
+func (s *mysqlStore) existingByKey(tides []Tide) (map[string]Tide, error) {
+	stationIDs := make(map[string]bool)
+	for _, t := range tides {
+		stationIDs[t.StationID] = true
+	}
+	ids := make([]string, 0, len(stationIDs))
+	for id := range stationIDs {
+		ids = append(ids, id)
+	}
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf("SELECT station_id, `date`, `day`, `time`, predictionft, predictioncm, highlow, `datetime` FROM tidedata WHERE station_id IN (%s)", placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]Tide)
+	for rows.Next() {
+		var t Tide
+		if err := rows.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime); err != nil {
+			return nil, err
+		}
+		existing[tideKey(t.StationID, t.DateTime)] = t
+	}
+	return existing, rows.Err()
+}
+
+func (s *mysqlStore) Query(from, to time.Time, highlow, station string) ([]Tide, error) {
+	query := "SELECT station_id, `date`, `day`, `time`, predictionft, predictioncm, highlow, `datetime` FROM tidedata WHERE (? IS NULL OR `datetime` >= ?) AND (? IS NULL OR `datetime` <= ?) AND (? = '' OR highlow = ?) AND (? = '' OR station_id = ?) ORDER BY `datetime`"
+
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	rows, err := s.db.Query(query, fromArg, fromArg, toArg, toArg, highlow, highlow, station, station)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tides []Tide
+	for rows.Next() {
+		var t Tide
+		if err := rows.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime); err != nil {
+			return nil, err
+		}
+		tides = append(tides, t)
+	}
+	return tides, rows.Err()
+}
+
+func (s *mysqlStore) NextTide(after time.Time, station string) (Tide, error) {
+	var t Tide
+	row := s.db.QueryRow("SELECT station_id, `date`, `day`, `time`, predictionft, predictioncm, highlow, `datetime` FROM tidedata WHERE `datetime` >= ? AND (? = '' OR station_id = ?) ORDER BY `datetime` LIMIT 1", after, station, station)
+	err := row.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime)
+	return t, err
+}
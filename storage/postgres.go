@@ -0,0 +1,236 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// postgresUpsertBatchSize is the number of rows sent per multi-row INSERT.
+const postgresUpsertBatchSize = 1000
+
+// postgresMigrations lists every schema change in order. Never edit a
+// migration once it has shipped; add a new one instead.
+var postgresMigrations = []struct {
+	Version int
+	SQL     string
+}{
+	{
+		Version: 1,
+		SQL: `CREATE TABLE IF NOT EXISTS tidedata(
+			uid serial NOT NULL,
+			station_id varchar(16),
+			datetime timestamp,
+			date varchar(16),
+			day varchar(16),
+			time varchar(16),
+			predictionft real,
+			predictioncm integer,
+			highlow varchar(16),
+			UNIQUE(station_id, datetime)
+		)`,
+	},
+}
+
+// postgresStore is a Store backed by lib/pq.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func newPostgresStore(dsn string) (Store, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &postgresStore{db: db}, nil
+}
+
+func (s *postgresStore) Close() error {
+	return s.db.Close()
+}
+
+// Migrate applies any postgresMigrations not yet recorded in
+// schema_migrations, in version order, each inside its own transaction.
+func (s *postgresStore) Migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations(version integer PRIMARY KEY, applied_at timestamp NOT NULL DEFAULT now())"); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := s.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %v", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range postgresMigrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %v", m.Version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations(version) VALUES($1)", m.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTides upserts tides in chunks of postgresUpsertBatchSize rows, using
+// the classic "xmax = 0" trick to tell inserted rows from updated ones, and
+// skipping the update (via the WHERE clause) for rows that already match so
+// they can be reported as unchanged.
+func (s *postgresStore) SaveTides(tides []Tide, dryRun bool) (UpsertResult, error) {
+	var total UpsertResult
+	for start := 0; start < len(tides); start += postgresUpsertBatchSize {
+		end := start + postgresUpsertBatchSize
+		if end > len(tides) {
+			end = len(tides)
+		}
+
+		result, err := s.upsertBatch(tides[start:end], dryRun)
+		if err != nil {
+			return total, err
+		}
+		total.Inserted += result.Inserted
+		total.Updated += result.Updated
+		total.Unchanged += result.Unchanged
+	}
+	return total, nil
+}
+
+func (s *postgresStore) upsertBatch(tides []Tide, dryRun bool) (UpsertResult, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	placeholders := make([]string, len(tides))
+	args := make([]interface{}, 0, len(tides)*8)
+	for i, t := range tides {
+		base := i * 8
+		placeholders[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+			base+1, base+2, base+3, base+4, base+5, base+6, base+7, base+8)
+		args = append(args, t.StationID, t.DateTime, t.Date, t.Day, t.Time, t.PredictionFt, t.PredictionCm, t.HighLow)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO tidedata(station_id, datetime, date, day, time, predictionft, predictioncm, highlow)
+VALUES %s
+ON CONFLICT (station_id, datetime) DO UPDATE SET
+	date = EXCLUDED.date,
+	day = EXCLUDED.day,
+	time = EXCLUDED.time,
+	predictionft = EXCLUDED.predictionft,
+	predictioncm = EXCLUDED.predictioncm,
+	highlow = EXCLUDED.highlow
+WHERE (tidedata.date, tidedata.day, tidedata.time, tidedata.predictionft, tidedata.predictioncm, tidedata.highlow)
+	IS DISTINCT FROM (EXCLUDED.date, EXCLUDED.day, EXCLUDED.time, EXCLUDED.predictionft, EXCLUDED.predictioncm, EXCLUDED.highlow)
+RETURNING (xmax = 0) AS inserted`, strings.Join(placeholders, ", "))
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		tx.Rollback()
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	var touched int
+	for rows.Next() {
+		var inserted bool
+		if err := rows.Scan(&inserted); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return UpsertResult{}, err
+		}
+		touched++
+		if inserted {
+			result.Inserted++
+		} else {
+			result.Updated++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		tx.Rollback()
+		return UpsertResult{}, err
+	}
+	rows.Close()
+	result.Unchanged = len(tides) - touched
+
+	if dryRun {
+		return result, tx.Rollback()
+	}
+	return result, tx.Commit()
+}
+
+// Query returns tides between from and to (inclusive), optionally filtered
+// by highlow and station. A zero from/to or empty station leaves that
+// filter unrestricted.
+func (s *postgresStore) Query(from, to time.Time, highlow, station string) ([]Tide, error) {
+	query := "SELECT station_id, date, day, time, predictionft, predictioncm, highlow, datetime FROM tidedata WHERE ($1::timestamp IS NULL OR datetime >= $1) AND ($2::timestamp IS NULL OR datetime <= $2) AND ($3 = '' OR highlow = $3) AND ($4 = '' OR station_id = $4) ORDER BY datetime"
+
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	rows, err := s.db.Query(query, fromArg, toArg, highlow, station)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tides []Tide
+	for rows.Next() {
+		var t Tide
+		if err := rows.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime); err != nil {
+			return nil, err
+		}
+		tides = append(tides, t)
+	}
+	return tides, rows.Err()
+}
+
+// NextTide returns the first tide event at or after the given time,
+// optionally restricted to one station.
+func (s *postgresStore) NextTide(after time.Time, station string) (Tide, error) {
+	var t Tide
+	row := s.db.QueryRow("SELECT station_id, date, day, time, predictionft, predictioncm, highlow, datetime FROM tidedata WHERE datetime >= $1 AND ($2 = '' OR station_id = $2) ORDER BY datetime LIMIT 1", after, station)
+	err := row.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime)
+	return t, err
+}
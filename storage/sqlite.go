@@ -0,0 +1,255 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteUpsertBatchSize is the number of rows sent per multi-row INSERT.
+const sqliteUpsertBatchSize = 1000
+
+// sqliteMigrations lists every schema change in order. Never edit a
+// migration once it has shipped; add a new one instead.
+var sqliteMigrations = []struct {
+	Version int
+	SQL     string
+}{
+	{
+		Version: 1,
+		SQL: `CREATE TABLE IF NOT EXISTS tidedata(
+			uid INTEGER PRIMARY KEY AUTOINCREMENT,
+			station_id TEXT,
+			datetime DATETIME,
+			date TEXT,
+			day TEXT,
+			time TEXT,
+			predictionft REAL,
+			predictioncm INTEGER,
+			highlow TEXT,
+			UNIQUE(station_id, datetime)
+		)`,
+	},
+}
+
+// sqliteStore is a Store backed by mattn/go-sqlite3, intended for running
+// TideCrawler standalone (e.g. on a Raspberry Pi) without a Postgres server.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(dsn string) (Store, error) {
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqliteStore) Migrate() error {
+	if _, err := s.db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations(version INTEGER PRIMARY KEY, applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)"); err != nil {
+		return fmt.Errorf("creating schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := s.db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %v", err)
+	}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range sqliteMigrations {
+		if applied[m.Version] {
+			continue
+		}
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("applying migration %d: %v", m.Version, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations(version) VALUES(?)", m.Version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %d: %v", m.Version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveTides upserts tides in chunks of sqliteUpsertBatchSize rows. SQLite
+// has no equivalent of Postgres's xmax system column, so inserted/updated/
+// unchanged are classified by comparing against existing rows before
+// writing, rather than inspecting the write itself.
+func (s *sqliteStore) SaveTides(tides []Tide, dryRun bool) (UpsertResult, error) {
+	var total UpsertResult
+	for start := 0; start < len(tides); start += sqliteUpsertBatchSize {
+		end := start + sqliteUpsertBatchSize
+		if end > len(tides) {
+			end = len(tides)
+		}
+
+		result, err := s.upsertBatch(tides[start:end], dryRun)
+		if err != nil {
+			return total, err
+		}
+		total.Inserted += result.Inserted
+		total.Updated += result.Updated
+		total.Unchanged += result.Unchanged
+	}
+	return total, nil
+}
+
+func (s *sqliteStore) upsertBatch(tides []Tide, dryRun bool) (UpsertResult, error) {
+	existing, err := s.existingByKey(tides)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	var result UpsertResult
+	for _, t := range tides {
+		switch prev, ok := existing[tideKey(t.StationID, t.DateTime)]; {
+		case !ok:
+			result.Inserted++
+		case prev.Equal(t):
+			result.Unchanged++
+		default:
+			result.Updated++
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+
+	placeholders := make([]string, len(tides))
+	args := make([]interface{}, 0, len(tides)*8)
+	for i, t := range tides {
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, t.StationID, t.DateTime, t.Date, t.Day, t.Time, t.PredictionFt, t.PredictionCm, t.HighLow)
+	}
+
+	query := fmt.Sprintf(`INSERT INTO tidedata(station_id, datetime, date, day, time, predictionft, predictioncm, highlow)
+VALUES %s
+ON CONFLICT(station_id, datetime) DO UPDATE SET
+	date = excluded.date,
+	day = excluded.day,
+	time = excluded.time,
+	predictionft = excluded.predictionft,
+	predictioncm = excluded.predictioncm,
+	highlow = excluded.highlow`, strings.Join(placeholders, ", "))
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		tx.Rollback()
+		return UpsertResult{}, err
+	}
+	return result, tx.Commit()
+}
+
+// existingByKey fetches the current rows for any of tides' (station_id,
+// datetime) keys, for use diffing against incoming data.
+func (s *sqliteStore) existingByKey(tides []Tide) (map[string]Tide, error) {
+	stationIDs := make(map[string]bool)
+	for _, t := range tides {
+		stationIDs[t.StationID] = true
+	}
+	ids := make([]string, 0, len(stationIDs))
+	for id := range stationIDs {
+		ids = append(ids, id)
+	}
+	placeholders := strings.TrimRight(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf("SELECT station_id, date, day, time, predictionft, predictioncm, highlow, datetime FROM tidedata WHERE station_id IN (%s)", placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]Tide)
+	for rows.Next() {
+		var t Tide
+		if err := rows.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime); err != nil {
+			return nil, err
+		}
+		existing[tideKey(t.StationID, t.DateTime)] = t
+	}
+	return existing, rows.Err()
+}
+
+func tideKey(stationID string, dateTime time.Time) string {
+	return stationID + "|" + dateTime.UTC().Format(time.RFC3339)
+}
+
+func (s *sqliteStore) Query(from, to time.Time, highlow, station string) ([]Tide, error) {
+	query := "SELECT station_id, date, day, time, predictionft, predictioncm, highlow, datetime FROM tidedata WHERE (? IS NULL OR datetime >= ?) AND (? IS NULL OR datetime <= ?) AND (? = '' OR highlow = ?) AND (? = '' OR station_id = ?) ORDER BY datetime"
+
+	var fromArg, toArg interface{}
+	if !from.IsZero() {
+		fromArg = from
+	}
+	if !to.IsZero() {
+		toArg = to
+	}
+
+	rows, err := s.db.Query(query, fromArg, fromArg, toArg, toArg, highlow, highlow, station, station)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tides []Tide
+	for rows.Next() {
+		var t Tide
+		if err := rows.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime); err != nil {
+			return nil, err
+		}
+		tides = append(tides, t)
+	}
+	return tides, rows.Err()
+}
+
+func (s *sqliteStore) NextTide(after time.Time, station string) (Tide, error) {
+	var t Tide
+	row := s.db.QueryRow("SELECT station_id, date, day, time, predictionft, predictioncm, highlow, datetime FROM tidedata WHERE datetime >= ? AND (? = '' OR station_id = ?) ORDER BY datetime LIMIT 1", after, station, station)
+	err := row.Scan(&t.StationID, &t.Date, &t.Day, &t.Time, &t.PredictionFt, &t.PredictionCm, &t.HighLow, &t.DateTime)
+	return t, err
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+// Package storage isolates TideCrawler's database access behind a Store
+// interface so the CLI, HTTP API and ICS export don't care which engine is
+// backing them, and so driver-specific dialect quirks (SERIAL vs
+// AUTOINCREMENT, timestamp handling, upsert syntax) stay out of the rest of
+// the codebase.
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tide is a single tide prediction as persisted by a Store.
+type Tide struct {
+	StationID    string
+	Date         string
+	Day          string
+	Time         string
+	PredictionFt float64
+	PredictionCm float64
+	HighLow      string
+	DateTime     time.Time
+}
+
+// Equal reports whether t and other represent the same tide prediction.
+// DateTime is compared by instant (time.Time.Equal) rather than by Go's
+// == operator, since a time scanned back from a database (typically UTC)
+// and one freshly parsed from NOAA data can represent the same instant
+// while carrying different Location values, which would make == spuriously
+// report them as different.
+func (t Tide) Equal(other Tide) bool {
+	return t.StationID == other.StationID &&
+		t.Date == other.Date &&
+		t.Day == other.Day &&
+		t.Time == other.Time &&
+		t.PredictionFt == other.PredictionFt &&
+		t.PredictionCm == other.PredictionCm &&
+		t.HighLow == other.HighLow &&
+		t.DateTime.Equal(other.DateTime)
+}
+
+// UpsertResult tallies the outcome of a SaveTides call.
+type UpsertResult struct {
+	Inserted  int
+	Updated   int
+	Unchanged int
+}
+
+// Store persists and queries tide predictions, independent of the
+// underlying database engine.
+type Store interface {
+	// Migrate brings the schema up to date, creating or altering tables as
+	// needed. It is safe to call on every startup.
+	Migrate() error
+
+	// SaveTides upserts tides, keyed by (StationID, DateTime), and reports
+	// how many were inserted, updated, or left unchanged. When dryRun is
+	// true, nothing is written; the counts describe what would happen.
+	SaveTides(tides []Tide, dryRun bool) (UpsertResult, error)
+
+	// Query returns tides between from and to (inclusive), optionally
+	// filtered by highlow and station. A zero from/to or empty station
+	// leaves that filter unrestricted.
+	Query(from, to time.Time, highlow, station string) ([]Tide, error)
+
+	// NextTide returns the first tide at or after after, optionally
+	// restricted to one station.
+	NextTide(after time.Time, station string) (Tide, error)
+
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// Open constructs a Store for the named driver ("postgres", "sqlite3", or
+// "mysql") connected to dsn. An empty driver defaults to "postgres" to
+// match TideCrawler's original behavior.
+func Open(driver, dsn string) (Store, error) {
+	switch driver {
+	case "postgres", "":
+		return newPostgresStore(dsn)
+	case "sqlite3":
+		return newSQLiteStore(dsn)
+	case "mysql":
+		return newMySQLStore(dsn)
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", driver)
+	}
+}
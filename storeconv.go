@@ -0,0 +1,50 @@
+// Copyright (c) 2016 John Beil.
+// Use of this source code is governed by the MIT License.
+// The MIT license can be found in the LICENSE file.
+
+package main
+
+import "github.com/johnbeil/TideCrawler/storage"
+
+// toStorageTides converts Tide records (which carry NOAA's XML tags and the
+// API's JSON tags) into the storage package's engine-agnostic Tide type.
+func toStorageTides(tides []Tide) []storage.Tide {
+	out := make([]storage.Tide, len(tides))
+	for i, t := range tides {
+		out[i] = storage.Tide{
+			StationID:    t.StationID,
+			Date:         t.Date,
+			Day:          t.Day,
+			Time:         t.Time,
+			PredictionFt: t.PredictionFt,
+			PredictionCm: t.PredictionCm,
+			HighLow:      t.HighLow,
+			DateTime:     t.DateTime,
+		}
+	}
+	return out
+}
+
+// fromStorageTide converts a storage.Tide back into a Tide for JSON/ICS
+// rendering.
+func fromStorageTide(t storage.Tide) Tide {
+	return Tide{
+		StationID:    t.StationID,
+		Date:         t.Date,
+		Day:          t.Day,
+		Time:         t.Time,
+		PredictionFt: t.PredictionFt,
+		PredictionCm: t.PredictionCm,
+		HighLow:      t.HighLow,
+		DateTime:     t.DateTime,
+	}
+}
+
+// fromStorageTides converts a slice of storage.Tide back into Tides.
+func fromStorageTides(tides []storage.Tide) []Tide {
+	out := make([]Tide, len(tides))
+	for i, t := range tides {
+		out[i] = fromStorageTide(t)
+	}
+	return out
+}
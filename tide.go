@@ -3,7 +3,8 @@
 // The MIT license can be found in the LICENSE file.
 
 // TideCrawler 0.1
-// Obtains annual tide forecasts for NOAA Station 9414275
+// Obtains annual tide forecasts for every station listed in the stations
+// config
 // Parses each tide prediction
 // Saves observation to database - TO DO
 
@@ -11,21 +12,28 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/xml"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
 	"golang.org/x/net/html/charset"
+
+	"github.com/johnbeil/TideCrawler/storage"
 )
 
+// maxConcurrentFetches bounds how many stations are fetched from NOAA at once.
+const maxConcurrentFetches = 4
+
 // Config stores database credentials
 type Config struct {
+	StorageDriver    string
+	DatabaseDSN      string
 	DatabaseURL      string
 	DatabaseUser     string
 	DatabasePassword string
@@ -40,26 +48,31 @@ type TideData struct {
 // Tide stores a single tide prediction
 type Tide struct {
 	// XMLName xml.Name `xml"data`
-	Date         string  `xml:"date"`
-	Day          string  `xml:"day"`
-	Time         string  `xml:"time"`
-	PredictionFt float64 `xml:"predictions_in_ft"`
-	PredictionCm float64 `xml:"predictions_in_cm"`
-	HighLow      string  `xml:"highlow"`
-	DateTime     time.Time
+	StationID    string    `xml:"-" json:"stationId"`
+	Date         string    `xml:"date" json:"date"`
+	Day          string    `xml:"day" json:"day"`
+	Time         string    `xml:"time" json:"time"`
+	PredictionFt float64   `xml:"predictions_in_ft" json:"predictionFt"`
+	PredictionCm float64   `xml:"predictions_in_cm" json:"predictionCm"`
+	HighLow      string    `xml:"highlow" json:"highLow"`
+	DateTime     time.Time `json:"dateTime"`
 }
 
-// NOAA URL for Annual Tide XML
-const noaaURL = "http://tidesandcurrents.noaa.gov/noaatidepredictions/NOAATidesFacade.jsp?datatype=Annual+XML&Stationid=9414275&text=datafiles"
+// Global variable for the storage backend, selected by STORAGE_DRIVER
+var store storage.Store
 
-// Timezone to use for all time formatting
-var timezone = "PST"
-
-// Global variable for database
-var db *sql.DB
+// Command-line flags
+var (
+	serve     = flag.Bool("serve", false, "start the HTTP API server after ingesting tide data")
+	httpAddr  = flag.String("http-addr", ":8080", "address for the HTTP API server to listen on")
+	exportICS = flag.String("export-ics", "", "write the fetched tides to the given .ics file and exit")
+	dryRun    = flag.Bool("dry-run", false, "report how many rows would be inserted/updated/unchanged without writing them")
+)
 
-// Fetches Annual tide data and processes XML data
+// Fetches Annual tide data for every configured station and processes the XML
 func main() {
+	flag.Parse()
+
 	// Start tide crawler
 	fmt.Println("Starting TideCrawler...")
 
@@ -67,139 +80,239 @@ func main() {
 	config := Config{}
 	loadConfig(&config)
 
-	// Initialize tides to hold annual tide predictions
-	var tides TideData
-
-	// Load database
-	dbinfo := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable",
-		config.DatabaseUser, config.DatabasePassword, config.DatabaseName)
-	var err error
-	db, err = sql.Open("postgres", dbinfo)
+	stationsPath := os.Getenv("STATIONS_CONFIG")
+	if stationsPath == "" {
+		stationsPath = defaultStationsConfig
+	}
+	stations, err := loadStations(stationsPath)
 	if err != nil {
-		log.Fatal("Error opening database connection:", err)
+		log.Fatal("Error loading stations config:", err)
 	}
-	defer db.Close()
 
-	// Check database connection
-	err = db.Ping()
+	// Open the configured storage backend
+	store, err = storage.Open(config.StorageDriver, config.DatabaseDSN)
 	if err != nil {
-		log.Fatal("Error: Could not establish connection with the database.", err)
+		log.Fatal("Error opening storage backend:", err)
 	}
+	defer store.Close()
 
-	// Fetch annual data and store as byte b
-	b := getDataFromURL(noaaURL)
-	// fmt.Println("b is:", reflect.TypeOf(b))
+	// Bring the schema up to date
+	if err := store.Migrate(); err != nil {
+		log.Fatal("Error running migrations:", err)
+	}
 
-	// Convert b from []uint8 to *bytes.Buffer
-	c := bytes.NewBuffer(b)
-	// fmt.Println("c is:", reflect.TypeOf(c))
+	// Fetch every station's annual data, up to maxConcurrentFetches at a time
+	tides := fetchAllStations(stations)
 
-	// Use decoder to unmarshal the XML since NOAA data is in ISO-8859-1 and
-	// Unmarshal only reads UTF-8
-	decoder := xml.NewDecoder(c)
-	decoder.CharsetReader = charset.NewReaderLabel
-	if err := decoder.Decode(&tides); err != nil {
-		log.Fatal("decoder error:", err)
+	// Upsert each fetched tide so re-running mid-year doesn't destroy
+	// already-loaded rows
+	result, err := store.SaveTides(toStorageTides(tides), *dryRun)
+	if err != nil {
+		log.Fatal("Error upserting tides:", err)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: %d would be inserted, %d updated, %d unchanged\n", result.Inserted, result.Updated, result.Unchanged)
+	} else {
+		fmt.Printf("Success. %d inserted, %d updated, %d unchanged\n", result.Inserted, result.Updated, result.Unchanged)
 	}
 
-	// Drop the existing tidedata table
-	dropTable()
+	if *exportICS != "" {
+		if err := writeICSFile(*exportICS, tides); err != nil {
+			log.Fatal("Error exporting tides.ics:", err)
+		}
+		fmt.Println("Wrote", *exportICS)
+		return
+	}
 
-	// Create a new empty tidedata table
-	createTable()
+	var mqttPub *mqttPublisher
+	if mqttConfig := loadMQTTConfig(); mqttConfig.Broker != "" {
+		mqttPub, err = startMQTT(mqttConfig, stations)
+		if err != nil {
+			log.Fatal("Error starting MQTT publisher:", err)
+		}
+		defer mqttPub.client.Disconnect(250)
+	}
 
-	// Iterate over each Tide in Tides and save in database
-	for _, d := range tides.Tides {
-		d.DateTime = formatTime(d)
-		saveTide(d)
-		// fmt.Printf("\t%s\n", d.DateTime)
-		// fmt.Println(d)
+	if *serve {
+		runServer(*httpAddr)
+	} else if mqttPub != nil {
+		waitForInterrupt()
 	}
-	fmt.Println("Success. Number of items saved to tidedata table is:", len(tides.Tides))
-	// fmt.Println(tides.TideData)
 
 	fmt.Println("Shutting down TideCrawler...")
 }
 
+// loadMQTTConfig reads the optional MQTT publisher configuration from the
+// environment. An empty Broker disables the publisher.
+func loadMQTTConfig() MQTTConfig {
+	clientID := os.Getenv("MQTT_CLIENT_ID")
+	if clientID == "" {
+		clientID = "tidecrawler"
+	}
+	return MQTTConfig{
+		Broker:   os.Getenv("MQTT_BROKER"),
+		ClientID: clientID,
+		Username: os.Getenv("MQTT_USERNAME"),
+		Password: os.Getenv("MQTT_PASSWORD"),
+		CAFile:   os.Getenv("MQTT_CA_FILE"),
+		CertFile: os.Getenv("MQTT_CERT_FILE"),
+		KeyFile:  os.Getenv("MQTT_KEY_FILE"),
+	}
+}
+
+// fetchAllStations fetches and parses annual tide data for each station,
+// using a bounded pool of workers, and returns every tide across all
+// stations that fetched successfully. A station whose fetch or XML decode
+// fails is logged and skipped rather than aborting the whole run; an
+// unparsable prediction date/time ends the process (see formatTime).
+func fetchAllStations(stations []Station) []Tide {
+	type result struct {
+		station Station
+		tides   []Tide
+		err     error
+	}
+
+	jobs := make(chan Station)
+	results := make(chan result)
+
+	var workers sync.WaitGroup
+	for i := 0; i < maxConcurrentFetches; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for s := range jobs {
+				tides, err := fetchStationTides(s)
+				results <- result{station: s, tides: tides, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, s := range stations {
+			jobs <- s
+		}
+		close(jobs)
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	var all []Tide
+	for r := range results {
+		if r.err != nil {
+			fmt.Println("Error fetching station", r.station.ID, ":", r.err)
+			continue
+		}
+		all = append(all, r.tides...)
+	}
+	return all
+}
+
+// fetchStationTides fetches and parses annual tide data for a single station.
+func fetchStationTides(s Station) ([]Tide, error) {
+	fmt.Println("Fetching data for station", s.ID, s.Name, "...")
+
+	loc, err := time.LoadLocation(s.Location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid location %q for station %s: %w", s.Location, s.ID, err)
+	}
+
+	b, err := getDataFromURL(s.url())
+	if err != nil {
+		return nil, err
+	}
+
+	// Use decoder to unmarshal the XML since NOAA data is in ISO-8859-1 and
+	// Unmarshal only reads UTF-8
+	var data TideData
+	decoder := xml.NewDecoder(bytes.NewBuffer(b))
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+
+	tides := make([]Tide, len(data.Tides))
+	for i, d := range data.Tides {
+		d.StationID = s.ID
+		d.DateTime = formatTime(d, loc)
+		tides[i] = d
+	}
+	return tides, nil
+}
+
 // Returns formatted tide data
 func (t Tide) String() string {
 	// stime := t.DateTime.UTC().Format(time.UnixDate)
 	return t.Date + " " + t.Day + " " + t.Time + " " + t.HighLow + " " + t.DateTime.UTC().Format(time.UnixDate)
 }
 
-// Given Tide struct, returns formatted date time
-func formatTime(d Tide) time.Time {
+// Given a Tide struct and the station's real IANA location, returns the
+// parsed date time in that location.
+func formatTime(d Tide, loc *time.Location) time.Time {
 	// Concatenate tide prediction data into string
-	rawtime := d.Date + " " + d.Time + " " + timezone
+	rawtime := d.Date + " " + d.Time
 
-	// Parse time given concatenated rawtime
-	t, err := time.Parse("2006/01/02 3:04 PM PST", rawtime)
+	// Parse time given concatenated rawtime directly into the station's
+	// location, so the resulting DateTime is correct everywhere it's used
+	// (storage, API, MQTT, ICS) rather than carrying a fabricated
+	// zero-offset zone.
+	t, err := time.ParseInLocation("2006/01/02 3:04 PM", rawtime, loc)
 	if err != nil {
 		log.Fatal("error processing rawtime:", err)
 	}
-	// set timezone for datetime and update time variable t
-	// loc, err := time.LoadLocation("America/Los_Angeles")
-	// if err != nil {
-	// 	log.Fatal("error processing location", err)
-	// }
-	// t = t.In(loc)
 	return t
 }
 
 // Given URL, returns raw data
-func getDataFromURL(url string) (body []byte) {
-	fmt.Println("Fetching data...")
+func getDataFromURL(url string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
-		log.Fatal("Error fetching data:", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal("ioutil error reading resp.Body:", err)
+		return nil, err
 	}
-	if resp.StatusCode == 200 {
-		fmt.Println("Fetch successful. Processing data...")
-	} else {
-		fmt.Println("Fetch returned unanticipated HTTP code:", resp.Status)
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
 	}
-	return
+	return body, nil
 }
 
-// Loads database credentials from environment variables
+// Loads configuration, including database credentials, from environment
+// variables
 func loadConfig(config *Config) {
+	config.StorageDriver = os.Getenv("STORAGE_DRIVER")
 	config.DatabaseUser = os.Getenv("DATABASEUSER")
 	config.DatabasePassword = os.Getenv("DATABASEPASSWORD")
 	config.DatabaseURL = os.Getenv("DATABASEURL")
 	config.DatabaseName = os.Getenv("DATABASENAME")
+	config.DatabaseDSN = buildDSN(config)
 	fmt.Println("Config is:", config)
 }
 
-// savePrediction inserts a tide struct into the database
-func saveTide(t Tide) {
-	_, err := db.Exec("INSERT INTO tidedata(datetime, date, day, time, predictionft, predictioncm, highlow) VALUES($1, $2, $3, $4, $5, $6, $7)", t.DateTime, t.Date, t.Day, t.Time, t.PredictionFt, t.PredictionCm, t.HighLow)
-	if err != nil {
-		log.Fatal("Error saving tide:", err)
-	}
-}
-
-// dropTable drops an existing table from the database
-func dropTable() {
-	_, err := db.Exec("DROP TABLE tidedata")
-	if err != nil {
-		log.Fatal("Error dropping table tidedata:", err)
-	} else {
-		fmt.Println("Dropped existing table tidedata...")
+// buildDSN returns the connection string for the configured storage driver.
+// DATABASE_DSN, if set, is used verbatim for any driver; otherwise a DSN is
+// built from the discrete DATABASE* variables in the style that driver
+// expects.
+func buildDSN(config *Config) string {
+	if dsn := os.Getenv("DATABASE_DSN"); dsn != "" {
+		return dsn
 	}
 
-}
-
-// createTable creates a new tidedata table in the database
-func createTable() {
-	_, err := db.Exec("CREATE TABLE tidedata(uid serial NOT NULL, datetime timestamp, date varchar(16), day varchar (16), time varchar(16), predictionft real, predictioncm integer, highlow varchar (16));")
-	if err != nil {
-		log.Fatal("Error creating table tidedata:", err)
-	} else {
-		fmt.Println("Created new table tidedata...")
+	switch config.StorageDriver {
+	case "sqlite3":
+		if config.DatabaseName == "" {
+			return "tidecrawler.db"
+		}
+		return config.DatabaseName
+	case "mysql":
+		return fmt.Sprintf("%s:%s@/%s", config.DatabaseUser, config.DatabasePassword, config.DatabaseName)
+	default: // "postgres" and unset
+		return fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable",
+			config.DatabaseUser, config.DatabasePassword, config.DatabaseName)
 	}
 }